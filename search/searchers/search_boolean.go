@@ -10,6 +10,7 @@
 package searchers
 
 import (
+	"fmt"
 	"math"
 
 	"github.com/blevesearch/bleve/index"
@@ -17,38 +18,241 @@ import (
 	"github.com/blevesearch/bleve/search/scorers"
 )
 
+// clauseMatcher reports how many SHOULD sub-clauses matched, for
+// minShouldMatch gating and the coord factor.
+type clauseMatcher interface {
+	MatchingClauses() int
+	NumClauses() int
+}
+
+// blockMaxScorer reports a per-block score upper bound, in the spirit of
+// block-max WAND.
+type blockMaxScorer interface {
+	// MaxScoreInBlock returns the max score achievable at or before
+	// uptoID, and the ID up to which that bound holds.
+	MaxScoreInBlock(uptoID index.IndexInternalID) (float64, index.IndexInternalID, error)
+}
+
+// costEstimator reports a cardinality estimate cheaper than Count(), used
+// by pickLeader to choose which clause drives iteration.
+type costEstimator interface {
+	Cost() uint64
+}
+
+// booleanClause identifies the sub-searcher currently driving iteration.
+type booleanClause int
+
+const (
+	clauseMust booleanClause = iota
+	clauseShould
+	clauseFilter
+)
+
 type BooleanSearcher struct {
 	indexReader     index.IndexReader
 	mustSearcher    search.Searcher
 	shouldSearcher  search.Searcher
 	mustNotSearcher search.Searcher
+	filterSearcher  search.Searcher
 	queryNorm       float64
 	currMust        *search.DocumentMatch
 	currShould      *search.DocumentMatch
 	currMustNot     *search.DocumentMatch
+	currFilter      *search.DocumentMatch
 	currentID       index.IndexInternalID
 	min             uint64
+	minShouldMatch  int
+	shouldRequired  bool
+	leader          booleanClause
+	shouldClauses   clauseMatcher
+	mustBlockMax    blockMaxScorer
+	shouldBlockMax  blockMaxScorer
 	scorer          *scorers.ConjunctionQueryScorer
 	matches         []*search.DocumentMatch
 	initialized     bool
 }
 
+// NewBooleanSearcher builds a searcher that combines MUST, SHOULD and
+// MUST NOT sub-searchers.
 func NewBooleanSearcher(indexReader index.IndexReader, mustSearcher search.Searcher, shouldSearcher search.Searcher, mustNotSearcher search.Searcher, explain bool) (*BooleanSearcher, error) {
+	return newBooleanSearcher(indexReader, mustSearcher, shouldSearcher, mustNotSearcher, nil, 0, explain)
+}
+
+// newBooleanSearcher additionally takes a FILTER sub-searcher (matches
+// like MUST but never scores) and minShouldMatch (requires at least that
+// many SHOULD sub-clauses to match; 0 adds no such minimum, though SHOULD
+// is still required when it is the only clause or its own Min() > 0).
+func newBooleanSearcher(indexReader index.IndexReader, mustSearcher search.Searcher, shouldSearcher search.Searcher, mustNotSearcher search.Searcher, filterSearcher search.Searcher, minShouldMatch int, explain bool) (*BooleanSearcher, error) {
 	// build our searcher
 	rv := BooleanSearcher{
 		indexReader:     indexReader,
 		mustSearcher:    mustSearcher,
 		shouldSearcher:  shouldSearcher,
 		mustNotSearcher: mustNotSearcher,
+		filterSearcher:  filterSearcher,
+		minShouldMatch:  minShouldMatch,
 		scorer:          scorers.NewConjunctionQueryScorer(explain),
 		matches:         make([]*search.DocumentMatch, 2),
 	}
+	if shouldSearcher != nil {
+		rv.shouldClauses, _ = shouldSearcher.(clauseMatcher)
+		rv.shouldBlockMax, _ = shouldSearcher.(blockMaxScorer)
+	}
+	if mustSearcher != nil {
+		rv.mustBlockMax, _ = mustSearcher.(blockMaxScorer)
+	}
+	if minShouldMatch > 0 {
+		if shouldSearcher == nil {
+			return nil, fmt.Errorf("minShouldMatch %d requires a should searcher", minShouldMatch)
+		}
+		// without a clause count, minShouldMatch >= 2 could never be
+		// satisfied; fail loudly instead of returning a dead searcher
+		if minShouldMatch > 1 && rv.shouldClauses == nil {
+			return nil, fmt.Errorf("minShouldMatch %d requires a should searcher that reports per-clause match counts (MatchingClauses/NumClauses)", minShouldMatch)
+		}
+	}
+	// should is non-optional when it's the only clause, minShouldMatch
+	// demands a minimum, or the should searcher already requires one
+	rv.shouldRequired = shouldSearcher != nil &&
+		(shouldSearcher.Min() > 0 ||
+			(mustSearcher == nil && filterSearcher == nil) ||
+			minShouldMatch > 0)
+	rv.leader = rv.pickLeader()
 	rv.computeQueryNorm()
 	return &rv, nil
 }
 
+// pickLeader picks the cheapest mandatory clause (MUST, FILTER, or
+// SHOULD when it isn't purely optional) to drive iteration.
+func (s *BooleanSearcher) pickLeader() booleanClause {
+	leader := clauseMust
+	bestCost := uint64(math.MaxUint64)
+	have := false
+
+	consider := func(clause booleanClause, searcher search.Searcher) {
+		cost := searcher.Count()
+		if ce, ok := searcher.(costEstimator); ok {
+			cost = ce.Cost()
+		}
+		if !have || cost < bestCost {
+			leader = clause
+			bestCost = cost
+			have = true
+		}
+	}
+
+	if s.mustSearcher != nil {
+		consider(clauseMust, s.mustSearcher)
+	}
+	if s.filterSearcher != nil {
+		consider(clauseFilter, s.filterSearcher)
+	}
+	if s.shouldRequired {
+		consider(clauseShould, s.shouldSearcher)
+	}
+	return leader
+}
+
+// leaderSearcher returns the sub-searcher currently driving iteration.
+func (s *BooleanSearcher) leaderSearcher() search.Searcher {
+	switch s.leader {
+	case clauseShould:
+		return s.shouldSearcher
+	case clauseFilter:
+		return s.filterSearcher
+	default:
+		return s.mustSearcher
+	}
+}
+
+// leaderCurr returns the leader's current DocumentMatch.
+func (s *BooleanSearcher) leaderCurr() *search.DocumentMatch {
+	switch s.leader {
+	case clauseShould:
+		return s.currShould
+	case clauseFilter:
+		return s.currFilter
+	default:
+		return s.currMust
+	}
+}
+
+// setLeaderCurr stores dm as the leader's current DocumentMatch.
+func (s *BooleanSearcher) setLeaderCurr(dm *search.DocumentMatch) {
+	switch s.leader {
+	case clauseShould:
+		s.currShould = dm
+	case clauseFilter:
+		s.currFilter = dm
+	default:
+		s.currMust = dm
+	}
+}
+
+// updateCurrentID syncs currentID with the leader's current position.
+func (s *BooleanSearcher) updateCurrentID() {
+	if curr := s.leaderCurr(); curr != nil {
+		s.currentID = curr.IndexInternalID
+	} else {
+		s.currentID = nil
+	}
+}
+
+// maxScoreUpperBound sums the MUST and SHOULD upper bounds at uptoID and
+// returns the ID up to which that sum remains valid. ok is false if any
+// contributing searcher doesn't support block-max bounds.
+func (s *BooleanSearcher) maxScoreUpperBound(uptoID index.IndexInternalID) (bound float64, validUpto index.IndexInternalID, ok bool) {
+	if s.mustSearcher != nil && s.mustBlockMax == nil {
+		return 0, nil, false
+	}
+	if s.shouldSearcher != nil && s.shouldBlockMax == nil {
+		return 0, nil, false
+	}
+
+	// validUpto is the tightest of the per-clause bounds
+	haveValidUpto := false
+	if s.mustBlockMax != nil {
+		mustBound, mustValidUpto, err := s.mustBlockMax.MaxScoreInBlock(uptoID)
+		if err != nil {
+			return 0, nil, false
+		}
+		bound += mustBound
+		if !haveValidUpto || mustValidUpto.Compare(validUpto) < 0 {
+			validUpto = mustValidUpto
+			haveValidUpto = true
+		}
+	}
+	if s.shouldBlockMax != nil {
+		shouldBound, shouldValidUpto, err := s.shouldBlockMax.MaxScoreInBlock(uptoID)
+		if err != nil {
+			return 0, nil, false
+		}
+		bound += shouldBound
+		if !haveValidUpto || shouldValidUpto.Compare(validUpto) < 0 {
+			validUpto = shouldValidUpto
+			haveValidUpto = true
+		}
+	}
+	if !haveValidUpto {
+		validUpto = uptoID
+	}
+	return bound, validUpto, true
+}
+
+// MaxScoreInBlock makes BooleanSearcher itself a blockMaxScorer, by
+// delegating to maxScoreUpperBound.
+func (s *BooleanSearcher) MaxScoreInBlock(uptoID index.IndexInternalID) (float64, index.IndexInternalID, error) {
+	bound, validUpto, ok := s.maxScoreUpperBound(uptoID)
+	if !ok {
+		return 0, nil, fmt.Errorf("nested BooleanSearcher has a clause that doesn't support block-max bounds")
+	}
+	return bound, validUpto, nil
+}
+
 func (s *BooleanSearcher) computeQueryNorm() {
 	// first calculate sum of squared weights
+	// filterSearcher is deliberately excluded: FILTER clauses never
+	// contribute to queryNorm or the final score
 	sumOfSquaredWeights := 0.0
 	if s.mustSearcher != nil {
 		sumOfSquaredWeights += s.mustSearcher.Weight()
@@ -57,8 +261,15 @@ func (s *BooleanSearcher) computeQueryNorm() {
 		sumOfSquaredWeights += s.shouldSearcher.Weight()
 	}
 
-	// now compute query norm from this
-	s.queryNorm = 1.0 / math.Sqrt(sumOfSquaredWeights)
+	// now compute query norm from this, guarding against a filter-only
+	// searcher (must == nil && should == nil), which would otherwise
+	// divide by zero and produce +Inf, propagating to NaN/Inf scores
+	// rather than the constant score a filter-only query should have
+	if sumOfSquaredWeights == 0 {
+		s.queryNorm = 1.0
+	} else {
+		s.queryNorm = 1.0 / math.Sqrt(sumOfSquaredWeights)
+	}
 	// finally tell all the downstream searchers the norm
 	if s.mustSearcher != nil {
 		s.mustSearcher.SetQueryNorm(s.queryNorm)
@@ -101,47 +312,107 @@ func (s *BooleanSearcher) initSearchers(ctx *search.SearchContext) error {
 		}
 	}
 
-	if s.mustSearcher != nil && s.currMust != nil {
-		s.currentID = s.currMust.IndexInternalID
-	} else if s.mustSearcher == nil && s.currShould != nil {
-		s.currentID = s.currShould.IndexInternalID
-	} else {
-		s.currentID = nil
+	if s.filterSearcher != nil {
+		if s.currFilter != nil {
+			ctx.DocumentMatchPool.Put(s.currFilter)
+		}
+		s.currFilter, err = s.filterSearcher.Next(ctx)
+		if err != nil {
+			return err
+		}
 	}
 
+	s.updateCurrentID()
+
 	s.initialized = true
 	return nil
 }
 
-func (s *BooleanSearcher) advanceNextMust(ctx *search.SearchContext, skipReturn *search.DocumentMatch) error {
-	var err error
+// advanceLeader moves the clause driving iteration to its next match.
+// skipReturn is excluded from the pool return since the caller is about
+// to hand it back as the result of Next/Advance.
+func (s *BooleanSearcher) advanceLeader(ctx *search.SearchContext, skipReturn *search.DocumentMatch) error {
+	curr := s.leaderCurr()
+	if curr != skipReturn {
+		ctx.DocumentMatchPool.Put(curr)
+	}
+	next, err := s.leaderSearcher().Next(ctx)
+	if err != nil {
+		return err
+	}
+	s.setLeaderCurr(next)
+	s.updateCurrentID()
+	return nil
+}
 
-	if s.mustSearcher != nil {
-		if s.currMust != skipReturn {
-			ctx.DocumentMatchPool.Put(s.currMust)
-		}
-		s.currMust, err = s.mustSearcher.Next(ctx)
+// catchUpMustNot advances the MUST NOT searcher to currentID and reports
+// whether the candidate is excluded.
+func (s *BooleanSearcher) catchUpMustNot(ctx *search.SearchContext) (excluded bool, err error) {
+	if s.mustNotSearcher == nil {
+		return false, nil
+	}
+	if s.currMustNot != nil && s.currMustNot.IndexInternalID.Compare(s.currentID) < 0 {
+		ctx.DocumentMatchPool.Put(s.currMustNot)
+		s.currMustNot, err = s.mustNotSearcher.Advance(ctx, s.currentID)
 		if err != nil {
-			return err
+			return false, err
 		}
-	} else if s.mustSearcher == nil {
-		if s.currShould != skipReturn {
-			ctx.DocumentMatchPool.Put(s.currShould)
+	}
+	return s.currMustNot != nil && s.currMustNot.IndexInternalID.Equals(s.currentID), nil
+}
+
+// catchUpFilter advances the FILTER searcher, when it is not the leader,
+// to currentID and reports whether the candidate satisfies it.
+func (s *BooleanSearcher) catchUpFilter(ctx *search.SearchContext) (ok bool, err error) {
+	if s.filterSearcher == nil {
+		return true, nil
+	}
+	if s.leader == clauseFilter {
+		return true, nil
+	}
+	if s.currFilter != nil && s.currFilter.IndexInternalID.Compare(s.currentID) < 0 {
+		ctx.DocumentMatchPool.Put(s.currFilter)
+		s.currFilter, err = s.filterSearcher.Advance(ctx, s.currentID)
+		if err != nil {
+			return false, err
 		}
-		s.currShould, err = s.shouldSearcher.Next(ctx)
+	}
+	return s.currFilter != nil && s.currFilter.IndexInternalID.Equals(s.currentID), nil
+}
+
+// catchUpMust advances the MUST searcher, when it is not the leader, to
+// currentID and reports whether the candidate satisfies it.
+func (s *BooleanSearcher) catchUpMust(ctx *search.SearchContext) (ok bool, err error) {
+	if s.mustSearcher == nil {
+		return true, nil
+	}
+	if s.leader == clauseMust {
+		return true, nil
+	}
+	if s.currMust != nil && s.currMust.IndexInternalID.Compare(s.currentID) < 0 {
+		ctx.DocumentMatchPool.Put(s.currMust)
+		s.currMust, err = s.mustSearcher.Advance(ctx, s.currentID)
 		if err != nil {
-			return err
+			return false, err
 		}
 	}
+	return s.currMust != nil && s.currMust.IndexInternalID.Equals(s.currentID), nil
+}
 
-	if s.mustSearcher != nil && s.currMust != nil {
-		s.currentID = s.currMust.IndexInternalID
-	} else if s.mustSearcher == nil && s.currShould != nil {
-		s.currentID = s.currShould.IndexInternalID
-	} else {
-		s.currentID = nil
+// catchUpShould advances the SHOULD searcher, when it is not the leader,
+// to currentID and reports whether it matches.
+func (s *BooleanSearcher) catchUpShould(ctx *search.SearchContext) (matches bool, err error) {
+	if s.shouldSearcher == nil {
+		return false, nil
 	}
-	return nil
+	if s.leader != clauseShould && s.currShould != nil && s.currShould.IndexInternalID.Compare(s.currentID) < 0 {
+		ctx.DocumentMatchPool.Put(s.currShould)
+		s.currShould, err = s.shouldSearcher.Advance(ctx, s.currentID)
+		if err != nil {
+			return false, err
+		}
+	}
+	return s.currShould != nil && s.currShould.IndexInternalID.Equals(s.currentID), nil
 }
 
 func (s *BooleanSearcher) Weight() float64 {
@@ -178,70 +449,65 @@ func (s *BooleanSearcher) Next(ctx *search.SearchContext) (*search.DocumentMatch
 	var rv *search.DocumentMatch
 
 	for s.currentID != nil {
-		if s.currMustNot != nil && s.currMustNot.IndexInternalID.Compare(s.currentID) < 0 {
-			if s.currMustNot != nil {
-				ctx.DocumentMatchPool.Put(s.currMustNot)
-			}
-			// advance must not searcher to our candidate entry
-			s.currMustNot, err = s.mustNotSearcher.Advance(ctx, s.currentID)
+		excludedByMustNot, err := s.catchUpMustNot(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if excludedByMustNot {
+			err = s.advanceLeader(ctx, nil)
 			if err != nil {
 				return nil, err
 			}
-			if s.currMustNot != nil && s.currMustNot.IndexInternalID.Equals(s.currentID) {
-				// the candidate is excluded
-				err = s.advanceNextMust(ctx, nil)
-				if err != nil {
-					return nil, err
-				}
-				continue
+			continue
+		}
+
+		filterOK, err := s.catchUpFilter(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !filterOK {
+			// filter clauses are required, like MUST, but never score
+			err = s.advanceLeader(ctx, nil)
+			if err != nil {
+				return nil, err
 			}
-		} else if s.currMustNot != nil && s.currMustNot.IndexInternalID.Equals(s.currentID) {
-			// the candidate is excluded
-			err = s.advanceNextMust(ctx, nil)
+			continue
+		}
+
+		mustOK, err := s.catchUpMust(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !mustOK {
+			err = s.advanceLeader(ctx, nil)
 			if err != nil {
 				return nil, err
 			}
 			continue
 		}
 
-		if s.currShould != nil && s.currShould.IndexInternalID.Compare(s.currentID) < 0 {
-			// advance should searcher to our candidate entry
-			if s.currShould != nil {
-				ctx.DocumentMatchPool.Put(s.currShould)
+		shouldMatches, err := s.catchUpShould(ctx)
+		if err != nil {
+			return nil, err
+		}
+		matchingClauses := 0
+		if shouldMatches {
+			matchingClauses = 1
+			if s.shouldClauses != nil {
+				matchingClauses = s.shouldClauses.MatchingClauses()
 			}
-			s.currShould, err = s.shouldSearcher.Advance(ctx, s.currentID)
+		}
+		shouldSatisfied := shouldMatches && matchingClauses >= s.minShouldMatch
+
+		if s.shouldRequired && !shouldSatisfied {
+			err = s.advanceLeader(ctx, nil)
 			if err != nil {
 				return nil, err
 			}
-			if s.currShould != nil && s.currShould.IndexInternalID.Equals(s.currentID) {
-				// score bonus matches should
-				var cons []*search.DocumentMatch
-				if s.currMust != nil {
-					cons = s.matches
-					cons[0] = s.currMust
-					cons[1] = s.currShould
-				} else {
-					cons = s.matches[0:1]
-					cons[0] = s.currShould
-				}
-				rv = s.scorer.Score(ctx, cons)
-				err = s.advanceNextMust(ctx, rv)
-				if err != nil {
-					return nil, err
-				}
-				break
-			} else if s.shouldSearcher.Min() == 0 {
-				// match is OK anyway
-				cons := s.matches[0:1]
-				cons[0] = s.currMust
-				rv = s.scorer.Score(ctx, cons)
-				err = s.advanceNextMust(ctx, rv)
-				if err != nil {
-					return nil, err
-				}
-				break
-			}
-		} else if s.currShould != nil && s.currShould.IndexInternalID.Equals(s.currentID) {
+			continue
+		}
+
+		if shouldMatches && shouldSatisfied {
 			// score bonus matches should
 			var cons []*search.DocumentMatch
 			if s.currMust != nil {
@@ -253,24 +519,42 @@ func (s *BooleanSearcher) Next(ctx *search.SearchContext) (*search.DocumentMatch
 				cons[0] = s.currShould
 			}
 			rv = s.scorer.Score(ctx, cons)
-			err = s.advanceNextMust(ctx, rv)
-			if err != nil {
-				return nil, err
+			if s.minShouldMatch > 0 && rv != nil {
+				rv.Score *= s.coordFactor(matchingClauses)
 			}
-			break
-		} else if s.shouldSearcher == nil || s.shouldSearcher.Min() == 0 {
-			// match is OK anyway
-			cons := s.matches[0:1]
-			cons[0] = s.currMust
-			rv = s.scorer.Score(ctx, cons)
-			err = s.advanceNextMust(ctx, rv)
+			err = s.advanceLeader(ctx, rv)
 			if err != nil {
 				return nil, err
 			}
 			break
+		} else {
+			// must alone (optionally anchored on filter, when there is
+			// no must clause) satisfies; should was absent or optional
+			anchor := s.currMust
+			if anchor == nil {
+				anchor = s.currFilter
+			}
+			if anchor != nil {
+				cons := s.matches[0:1]
+				cons[0] = anchor
+				if anchor == s.currFilter {
+					// filter never scores; score a copy so we don't mutate
+					// s.currFilter itself
+					filterAsZeroScore := *anchor
+					filterAsZeroScore.Score = 0
+					filterAsZeroScore.Expl = nil
+					cons[0] = &filterAsZeroScore
+				}
+				rv = s.scorer.Score(ctx, cons)
+				err = s.advanceLeader(ctx, rv)
+				if err != nil {
+					return nil, err
+				}
+				break
+			}
 		}
 
-		err = s.advanceNextMust(ctx, nil)
+		err = s.advanceLeader(ctx, nil)
 		if err != nil {
 			return nil, err
 		}
@@ -278,6 +562,19 @@ func (s *BooleanSearcher) Next(ctx *search.SearchContext) (*search.DocumentMatch
 	return rv, nil
 }
 
+// coordFactor is the Lucene-style coordination factor for a candidate
+// matching matchingClauses of the SHOULD sub-clauses.
+func (s *BooleanSearcher) coordFactor(matchingClauses int) float64 {
+	if s.shouldClauses == nil {
+		return 1.0
+	}
+	total := s.shouldClauses.NumClauses()
+	if total <= 0 {
+		return 1.0
+	}
+	return float64(matchingClauses) / float64(total)
+}
+
 func (s *BooleanSearcher) Advance(ctx *search.SearchContext, ID index.IndexInternalID) (*search.DocumentMatch, error) {
 
 	if !s.initialized {
@@ -315,15 +612,18 @@ func (s *BooleanSearcher) Advance(ctx *search.SearchContext, ID index.IndexInter
 			return nil, err
 		}
 	}
-
-	if s.mustSearcher != nil && s.currMust != nil {
-		s.currentID = s.currMust.IndexInternalID
-	} else if s.mustSearcher == nil && s.currShould != nil {
-		s.currentID = s.currShould.IndexInternalID
-	} else {
-		s.currentID = nil
+	if s.filterSearcher != nil {
+		if s.currFilter != nil {
+			ctx.DocumentMatchPool.Put(s.currFilter)
+		}
+		s.currFilter, err = s.filterSearcher.Advance(ctx, ID)
+		if err != nil {
+			return nil, err
+		}
 	}
 
+	s.updateCurrentID()
+
 	return s.Next(ctx)
 }
 
@@ -337,6 +637,9 @@ func (s *BooleanSearcher) Count() uint64 {
 	if s.shouldSearcher != nil {
 		sum += s.shouldSearcher.Count()
 	}
+	if s.filterSearcher != nil {
+		sum += s.filterSearcher.Count()
+	}
 	return sum
 }
 
@@ -359,6 +662,12 @@ func (s *BooleanSearcher) Close() error {
 			return err
 		}
 	}
+	if s.filterSearcher != nil {
+		err := s.filterSearcher.Close()
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -367,7 +676,7 @@ func (s *BooleanSearcher) Min() int {
 }
 
 func (s *BooleanSearcher) DocumentMatchPoolSize() int {
-	rv := 3
+	rv := 4
 	if s.mustSearcher != nil {
 		rv += s.mustSearcher.DocumentMatchPoolSize()
 	}
@@ -377,5 +686,8 @@ func (s *BooleanSearcher) DocumentMatchPoolSize() int {
 	if s.mustNotSearcher != nil {
 		rv += s.mustNotSearcher.DocumentMatchPoolSize()
 	}
+	if s.filterSearcher != nil {
+		rv += s.filterSearcher.DocumentMatchPoolSize()
+	}
 	return rv
 }
@@ -0,0 +1,377 @@
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package searchers
+
+import (
+	"math"
+	"testing"
+
+	"github.com/blevesearch/bleve/index"
+	"github.com/blevesearch/bleve/search"
+)
+
+// stubSearcher is a fake search.Searcher backed by a fixed, sorted list of
+// document matches, used to exercise BooleanSearcher without depending on
+// a real posting-list implementation.
+type stubSearcher struct {
+	matches         []*search.DocumentMatch
+	pos             int
+	weight          float64
+	advanceRequests []index.IndexInternalID
+}
+
+func newStubSearcher(ids ...string) *stubSearcher {
+	matches := make([]*search.DocumentMatch, len(ids))
+	for i, id := range ids {
+		matches[i] = &search.DocumentMatch{IndexInternalID: index.IndexInternalID(id)}
+	}
+	return &stubSearcher{matches: matches, weight: 1.0}
+}
+
+func (s *stubSearcher) Next(ctx *search.SearchContext) (*search.DocumentMatch, error) {
+	if s.pos >= len(s.matches) {
+		return nil, nil
+	}
+	m := s.matches[s.pos]
+	s.pos++
+	return m, nil
+}
+
+func (s *stubSearcher) Advance(ctx *search.SearchContext, ID index.IndexInternalID) (*search.DocumentMatch, error) {
+	s.advanceRequests = append(s.advanceRequests, ID)
+	for s.pos < len(s.matches) && s.matches[s.pos].IndexInternalID.Compare(ID) < 0 {
+		s.pos++
+	}
+	return s.Next(ctx)
+}
+
+func (s *stubSearcher) Close() error           { return nil }
+func (s *stubSearcher) Weight() float64        { return s.weight }
+func (s *stubSearcher) SetQueryNorm(n float64) {}
+func (s *stubSearcher) Count() uint64          { return uint64(len(s.matches)) }
+func (s *stubSearcher) Min() int               { return 0 }
+func (s *stubSearcher) DocumentMatchPoolSize() int {
+	return 1
+}
+
+// stubShouldSearcher additionally reports per-clause match counts, like the
+// disjunction searcher, so it can be used to test minShouldMatch.
+type stubShouldSearcher struct {
+	*stubSearcher
+	numClauses      int
+	matchingClauses int
+}
+
+func (s *stubShouldSearcher) MatchingClauses() int { return s.matchingClauses }
+func (s *stubShouldSearcher) NumClauses() int      { return s.numClauses }
+
+// stubMinSearcher reports its own Min() > 0, like a disjunction searcher
+// built from a DisjunctionQuery with Min set, so it can be used to test
+// that BooleanSearcher still honors that pre-existing requirement.
+type stubMinSearcher struct {
+	*stubSearcher
+	min int
+}
+
+func (s *stubMinSearcher) Min() int { return s.min }
+
+// stubBlockMaxSearcher additionally reports a per-block score upper
+// bound, like a posting-list searcher would, so it can be used to test
+// BooleanSearcher's block-max upper bounds.
+type stubBlockMaxSearcher struct {
+	*stubSearcher
+	scoreFn func(uptoID index.IndexInternalID) (float64, index.IndexInternalID, error)
+}
+
+func (s *stubBlockMaxSearcher) MaxScoreInBlock(uptoID index.IndexInternalID) (float64, index.IndexInternalID, error) {
+	return s.scoreFn(uptoID)
+}
+
+func newSearchContext() *search.SearchContext {
+	return &search.SearchContext{
+		DocumentMatchPool: search.NewDocumentMatchPool(4, 0),
+	}
+}
+
+func TestNewBooleanSearcherRejectsMinShouldMatchWithoutClauseMatcher(t *testing.T) {
+	should := newStubSearcher("a", "b")
+	_, err := newBooleanSearcher(nil, nil, should, nil, nil, 2, false)
+	if err == nil {
+		t.Fatal("expected an error when minShouldMatch > 1 but should searcher can't report match counts")
+	}
+}
+
+func TestBooleanSearcherMinShouldMatch(t *testing.T) {
+	should := &stubShouldSearcher{
+		stubSearcher:    newStubSearcher("a"),
+		numClauses:      2,
+		matchingClauses: 1,
+	}
+	bs, err := newBooleanSearcher(nil, nil, should, nil, nil, 2, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx := newSearchContext()
+	dm, err := bs.Next(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dm != nil {
+		t.Fatalf("expected no match, since only 1 of 2 required should clauses matched, got %v", dm)
+	}
+}
+
+func TestBooleanSearcherHonorsShouldSearcherMin(t *testing.T) {
+	must := newStubSearcher("a", "b")
+	should := &stubMinSearcher{
+		stubSearcher: newStubSearcher("b"),
+		min:          1,
+	}
+	bs, err := newBooleanSearcher(nil, must, should, nil, nil, 0, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bs.shouldRequired {
+		t.Fatal("expected shouldRequired=true when the should searcher's own Min() > 0, even alongside a MUST clause")
+	}
+	ctx := newSearchContext()
+	dm, err := bs.Next(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dm == nil || !dm.IndexInternalID.Equals(index.IndexInternalID("b")) {
+		t.Fatalf("expected the first match to be b (where should also matches), got %v", dm)
+	}
+}
+
+func TestBooleanSearcherFilterOnlyQueryNormIsFinite(t *testing.T) {
+	filter := newStubSearcher("a", "b")
+	bs, err := newBooleanSearcher(nil, nil, nil, nil, filter, 0, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if math.IsInf(bs.queryNorm, 0) || math.IsNaN(bs.queryNorm) {
+		t.Fatalf("expected a finite queryNorm for a filter-only searcher, got %v", bs.queryNorm)
+	}
+}
+
+func TestBooleanSearcherFilterOnlyNextIsZeroScore(t *testing.T) {
+	filter := newStubSearcher("a", "b")
+	bs, err := newBooleanSearcher(nil, nil, nil, nil, filter, 0, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx := newSearchContext()
+	dm, err := bs.Next(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dm == nil || !dm.IndexInternalID.Equals(index.IndexInternalID("a")) {
+		t.Fatalf("expected the filter's first match (a), got %v", dm)
+	}
+	if dm.Score != 0 {
+		t.Fatalf("expected a filter-only match to score 0, got %v", dm.Score)
+	}
+}
+
+func TestBooleanSearcherMustAndFilterExcludesNonFilterMatches(t *testing.T) {
+	must := newStubSearcher("a", "b", "c")
+	filter := newStubSearcher("b", "c")
+	bs, err := newBooleanSearcher(nil, must, nil, nil, filter, 0, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx := newSearchContext()
+	dm, err := bs.Next(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dm == nil || !dm.IndexInternalID.Equals(index.IndexInternalID("b")) {
+		t.Fatalf("expected a (must-only) to be excluded by the filter and b to match first, got %v", dm)
+	}
+	dm, err = bs.Next(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dm == nil || !dm.IndexInternalID.Equals(index.IndexInternalID("c")) {
+		t.Fatalf("expected c to match next, got %v", dm)
+	}
+}
+
+func TestBooleanSearcherCountIncludesFilter(t *testing.T) {
+	filter := newStubSearcher("a", "b", "c")
+	bs, err := newBooleanSearcher(nil, nil, nil, nil, filter, 0, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := bs.Count(), uint64(3); got != want {
+		t.Fatalf("Count() = %d, want %d (filter-only searcher's count)", got, want)
+	}
+}
+
+func TestMaxScoreUpperBoundCombinesMustAndShould(t *testing.T) {
+	must := &stubBlockMaxSearcher{
+		stubSearcher: newStubSearcher("a", "b"),
+		scoreFn: func(index.IndexInternalID) (float64, index.IndexInternalID, error) {
+			return 2.0, index.IndexInternalID("z"), nil
+		},
+	}
+	should := &stubBlockMaxSearcher{
+		stubSearcher: newStubSearcher("a"),
+		scoreFn: func(index.IndexInternalID) (float64, index.IndexInternalID, error) {
+			return 1.5, index.IndexInternalID("y"), nil
+		},
+	}
+	bs, err := newBooleanSearcher(nil, must, should, nil, nil, 0, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bound, validUpto, ok := bs.maxScoreUpperBound(index.IndexInternalID("a"))
+	if !ok {
+		t.Fatal("expected a valid bound when both must and should support block-max")
+	}
+	if bound != 3.5 {
+		t.Fatalf("bound = %v, want 3.5 (2.0 must + 1.5 should)", bound)
+	}
+	if !validUpto.Equals(index.IndexInternalID("y")) {
+		t.Fatalf("validUpto = %v, want the tighter of the two bounds (y)", validUpto)
+	}
+}
+
+func TestMaxScoreUpperBoundNotOKWithoutBlockMaxSupport(t *testing.T) {
+	must := newStubSearcher("a", "b")
+	bs, err := newBooleanSearcher(nil, must, nil, nil, nil, 0, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, _, ok := bs.maxScoreUpperBound(index.IndexInternalID("a")); ok {
+		t.Fatal("expected ok=false when the must searcher doesn't implement blockMaxScorer")
+	}
+}
+
+func TestNestedBooleanSearcherIsABlockMaxScorer(t *testing.T) {
+	// the inner BooleanSearcher's own MUST clause supports block-max
+	// bounds, so the inner searcher itself should satisfy blockMaxScorer,
+	// with no stub involved.
+	innerMust := &stubBlockMaxSearcher{
+		stubSearcher: newStubSearcher("a", "b", "c", "d"),
+		scoreFn: func(uptoID index.IndexInternalID) (float64, index.IndexInternalID, error) {
+			return 2.0, index.IndexInternalID("d"), nil
+		},
+	}
+	inner, err := newBooleanSearcher(nil, innerMust, nil, nil, nil, 0, false)
+	if err != nil {
+		t.Fatalf("unexpected error building inner searcher: %v", err)
+	}
+
+	outer, err := newBooleanSearcher(nil, inner, nil, nil, nil, 0, false)
+	if err != nil {
+		t.Fatalf("unexpected error building outer searcher: %v", err)
+	}
+	if outer.mustBlockMax == nil {
+		t.Fatal("expected the outer searcher to recognize the nested BooleanSearcher as a blockMaxScorer")
+	}
+	bound, validUpto, err := outer.mustBlockMax.MaxScoreInBlock(index.IndexInternalID("a"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bound != 2.0 || !validUpto.Equals(index.IndexInternalID("d")) {
+		t.Fatalf("bound, validUpto = %v, %v, want 2.0, d (from the inner must clause)", bound, validUpto)
+	}
+}
+
+func TestPickLeaderPrefersCheaperShould(t *testing.T) {
+	must := newStubSearcher("a", "b", "c", "d", "e", "f", "g", "h", "i", "j")
+	should := newStubSearcher("a", "b", "c")
+	bs, err := newBooleanSearcher(nil, must, should, nil, nil, 1, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bs.leader != clauseShould {
+		t.Fatalf("expected should (cardinality 3) to lead over must (cardinality 10), got leader=%v", bs.leader)
+	}
+}
+
+func TestPickLeaderPrefersCheaperFilter(t *testing.T) {
+	must := newStubSearcher("a", "b", "c", "d", "e", "f", "g", "h", "i", "j")
+	filter := newStubSearcher("a", "b")
+	bs, err := newBooleanSearcher(nil, must, nil, nil, filter, 0, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bs.leader != clauseFilter {
+		t.Fatalf("expected filter (cardinality 2) to lead over must (cardinality 10), got leader=%v", bs.leader)
+	}
+}
+
+// stubCostSearcher additionally reports a cardinality estimate cheaper
+// than its own Count(), like a real cost estimator would, so it can be
+// used to test pickLeader's use of Cost() over Count().
+type stubCostSearcher struct {
+	*stubSearcher
+	cost uint64
+}
+
+func (s *stubCostSearcher) Cost() uint64 { return s.cost }
+
+func TestPickLeaderUsesCostOverCount(t *testing.T) {
+	must := newStubSearcher("a", "b", "c", "d", "e")
+	should := &stubCostSearcher{
+		stubSearcher: newStubSearcher("a", "b", "c", "d", "e", "f", "g", "h", "i", "j"),
+		cost:         1,
+	}
+	bs, err := newBooleanSearcher(nil, must, should, nil, nil, 1, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bs.leader != clauseShould {
+		t.Fatalf("expected should to lead via its cheaper Cost() estimate despite a higher Count(), got leader=%v", bs.leader)
+	}
+}
+
+// TestNewBooleanSearcherEndToEnd exercises the real entry point every
+// existing caller uses (NewBooleanSearcher, not newBooleanSearcher), to
+// confirm pickLeader and Next() still behave as before the leader
+// selection refactor: MUST leads (an optional SHOULD is never a leader
+// candidate), SHOULD is an optional scoring bonus, and MUST NOT excludes "b".
+func TestNewBooleanSearcherEndToEnd(t *testing.T) {
+	must := newStubSearcher("a", "b", "c")
+	should := newStubSearcher("a", "c", "d", "e", "f", "g")
+	mustNot := newStubSearcher("b")
+	bs, err := NewBooleanSearcher(nil, must, should, mustNot, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bs.leader != clauseMust {
+		t.Fatalf("expected must to lead over the optional should, got leader=%v", bs.leader)
+	}
+
+	ctx := newSearchContext()
+	var got []string
+	for {
+		dm, err := bs.Next(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if dm == nil {
+			break
+		}
+		got = append(got, string(dm.IndexInternalID))
+	}
+	want := []string{"a", "c"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package searchers
+
+import (
+	"math"
+
+	"github.com/blevesearch/bleve/index"
+	"github.com/blevesearch/bleve/search"
+	"github.com/blevesearch/bleve/search/scorers"
+)
+
+// DisjunctionSearcher combines a set of sub-searchers where at least min
+// of them must match (any one, when min is 0 or 1), used as a SHOULD clause on its own or nested
+// inside a BooleanSearcher. It reports MatchingClauses/NumClauses, so it
+// is a real (non-stub) clauseMatcher for BooleanSearcher's minShouldMatch.
+type DisjunctionSearcher struct {
+	indexReader index.IndexReader
+	searchers   []search.Searcher
+	currs       []*search.DocumentMatch
+	matching    []*search.DocumentMatch
+	currentID   index.IndexInternalID
+	min         int
+	queryNorm   float64
+	scorer      *scorers.DisjunctionQueryScorer
+	initialized bool
+}
+
+func NewDisjunctionSearcher(indexReader index.IndexReader, searchers []search.Searcher, min int, explain bool) (*DisjunctionSearcher, error) {
+	rv := DisjunctionSearcher{
+		indexReader: indexReader,
+		searchers:   searchers,
+		currs:       make([]*search.DocumentMatch, len(searchers)),
+		matching:    make([]*search.DocumentMatch, 0, len(searchers)),
+		min:         min,
+		scorer:      scorers.NewDisjunctionQueryScorer(explain),
+	}
+	rv.computeQueryNorm()
+	return &rv, nil
+}
+
+func (s *DisjunctionSearcher) computeQueryNorm() {
+	sumOfSquaredWeights := 0.0
+	for _, searcher := range s.searchers {
+		sumOfSquaredWeights += searcher.Weight()
+	}
+	s.queryNorm = 1.0 / math.Sqrt(sumOfSquaredWeights)
+	for _, searcher := range s.searchers {
+		searcher.SetQueryNorm(s.queryNorm)
+	}
+}
+
+func (s *DisjunctionSearcher) initSearchers(ctx *search.SearchContext) error {
+	var err error
+	for i, searcher := range s.searchers {
+		if s.currs[i] != nil {
+			ctx.DocumentMatchPool.Put(s.currs[i])
+		}
+		s.currs[i], err = searcher.Next(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	s.updateCurrentID()
+	s.initialized = true
+	return nil
+}
+
+// updateCurrentID sets currentID to the lowest ID still pending among
+// the sub-searchers' current matches.
+func (s *DisjunctionSearcher) updateCurrentID() {
+	var found bool
+	var lowest index.IndexInternalID
+	for _, curr := range s.currs {
+		if curr == nil {
+			continue
+		}
+		if !found || curr.IndexInternalID.Compare(lowest) < 0 {
+			lowest = curr.IndexInternalID
+			found = true
+		}
+	}
+	if found {
+		s.currentID = lowest
+	} else {
+		s.currentID = nil
+	}
+}
+
+// MatchingClauses reports how many sub-searchers matched the candidate
+// most recently returned by Next/Advance.
+func (s *DisjunctionSearcher) MatchingClauses() int {
+	return len(s.matching)
+}
+
+// NumClauses reports the total number of SHOULD sub-clauses.
+func (s *DisjunctionSearcher) NumClauses() int {
+	return len(s.searchers)
+}
+
+func (s *DisjunctionSearcher) Weight() float64 {
+	var rv float64
+	for _, searcher := range s.searchers {
+		rv += searcher.Weight()
+	}
+	return rv
+}
+
+func (s *DisjunctionSearcher) SetQueryNorm(qnorm float64) {
+	for _, searcher := range s.searchers {
+		searcher.SetQueryNorm(qnorm)
+	}
+}
+
+func (s *DisjunctionSearcher) Next(ctx *search.SearchContext) (*search.DocumentMatch, error) {
+	if !s.initialized {
+		err := s.initSearchers(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var rv *search.DocumentMatch
+	for s.currentID != nil {
+		s.matching = s.matching[:0]
+		for _, curr := range s.currs {
+			if curr != nil && curr.IndexInternalID.Equals(s.currentID) {
+				s.matching = append(s.matching, curr)
+			}
+		}
+
+		if len(s.matching) >= s.min {
+			rv = s.scorer.Score(ctx, s.matching, len(s.matching), len(s.searchers))
+		}
+
+		var err error
+		for i, curr := range s.currs {
+			if curr != nil && curr.IndexInternalID.Equals(s.currentID) {
+				// rv is handed back to the caller, so it stays out of the pool
+				if curr != rv {
+					ctx.DocumentMatchPool.Put(curr)
+				}
+				s.currs[i], err = s.searchers[i].Next(ctx)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		s.updateCurrentID()
+
+		if rv != nil {
+			break
+		}
+	}
+	return rv, nil
+}
+
+func (s *DisjunctionSearcher) Advance(ctx *search.SearchContext, ID index.IndexInternalID) (*search.DocumentMatch, error) {
+	if !s.initialized {
+		err := s.initSearchers(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	for i, searcher := range s.searchers {
+		if s.currs[i] != nil {
+			ctx.DocumentMatchPool.Put(s.currs[i])
+		}
+		s.currs[i], err = searcher.Advance(ctx, ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.updateCurrentID()
+
+	return s.Next(ctx)
+}
+
+func (s *DisjunctionSearcher) Count() uint64 {
+	var sum uint64
+	for _, searcher := range s.searchers {
+		sum += searcher.Count()
+	}
+	return sum
+}
+
+func (s *DisjunctionSearcher) Close() error {
+	for _, searcher := range s.searchers {
+		err := searcher.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *DisjunctionSearcher) Min() int {
+	return s.min
+}
+
+func (s *DisjunctionSearcher) DocumentMatchPoolSize() int {
+	rv := len(s.searchers)
+	for _, searcher := range s.searchers {
+		rv += searcher.DocumentMatchPoolSize()
+	}
+	return rv
+}
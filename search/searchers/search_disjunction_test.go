@@ -0,0 +1,104 @@
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package searchers
+
+import (
+	"testing"
+
+	"github.com/blevesearch/bleve/index"
+	"github.com/blevesearch/bleve/search"
+)
+
+func TestDisjunctionSearcherMatchesAnyClause(t *testing.T) {
+	a := newStubSearcher("1", "3")
+	b := newStubSearcher("2", "3")
+	ds, err := NewDisjunctionSearcher(nil, []search.Searcher{a, b}, 0, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx := newSearchContext()
+
+	want := []string{"1", "2", "3"}
+	for _, id := range want {
+		dm, err := ds.Next(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if dm == nil || !dm.IndexInternalID.Equals(index.IndexInternalID(id)) {
+			t.Fatalf("expected %s, got %v", id, dm)
+		}
+	}
+	dm, err := ds.Next(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dm != nil {
+		t.Fatalf("expected no more matches, got %v", dm)
+	}
+}
+
+func TestDisjunctionSearcherMatchingClauses(t *testing.T) {
+	a := newStubSearcher("1", "3")
+	b := newStubSearcher("2", "3")
+	ds, err := NewDisjunctionSearcher(nil, []search.Searcher{a, b}, 0, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx := newSearchContext()
+
+	// "1" only matches the first clause
+	if _, err := ds.Next(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := ds.MatchingClauses(), 1; got != want {
+		t.Fatalf("MatchingClauses() = %d, want %d for doc 1", got, want)
+	}
+
+	// "2" only matches the second clause
+	if _, err := ds.Next(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := ds.MatchingClauses(), 1; got != want {
+		t.Fatalf("MatchingClauses() = %d, want %d for doc 2", got, want)
+	}
+
+	// "3" matches both clauses
+	if _, err := ds.Next(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := ds.MatchingClauses(), 2; got != want {
+		t.Fatalf("MatchingClauses() = %d, want %d for doc 3", got, want)
+	}
+	if got, want := ds.NumClauses(), 2; got != want {
+		t.Fatalf("NumClauses() = %d, want %d", got, want)
+	}
+}
+
+func TestBooleanSearcherMinShouldMatchWithRealDisjunctionSearcher(t *testing.T) {
+	a := newStubSearcher("1", "2", "3")
+	b := newStubSearcher("2", "3")
+	should, err := NewDisjunctionSearcher(nil, []search.Searcher{a, b}, 0, false)
+	if err != nil {
+		t.Fatalf("unexpected error building should searcher: %v", err)
+	}
+	bs, err := newBooleanSearcher(nil, nil, should, nil, nil, 2, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx := newSearchContext()
+
+	dm, err := bs.Next(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dm == nil || !dm.IndexInternalID.Equals(index.IndexInternalID("2")) {
+		t.Fatalf("expected doc 2 (matches both disjunction clauses) to be the first result, got %v", dm)
+	}
+}